@@ -1,26 +1,38 @@
 // The restructure tool recovers control flow primitives from control flow
 // graphs (*.dot -> *.json).
 //
-// The input of restructure is a Graphviz DOT file, containing the unstructured
-// control flow graph of a function, and the output is a JSON stream describing
-// how the recovered high-level control flow primitives relate to the nodes of
-// the control flow graph.
+// The input of restructure is one or more Graphviz DOT files (or a directory
+// searched recursively for *.dot files), each containing the unstructured
+// control flow graph of a function, and the output is, for each input, a
+// JSON file describing how the recovered high-level control flow primitives
+// relate to the nodes of the control flow graph. When more than one input is
+// given, a top-level manifest.json is also produced, summarizing the result
+// of every job; this makes restructure suitable as a stage in a pipeline
+// that decomposes a whole binary's worth of functions.
 //
 // Usage:
 //
-//     restructure [OPTION]... [FILE.dot]
+//     restructure [OPTION]... [FILE.dot]...
+//     restructure [OPTION]... DIR
 //
 // Flags:
 //
+//   -analyze string
+//         comma-separated list of dataflow analyses to run and dump as a
+//         sidecar JSON file (reach, dom-frontier, loops)
 //   -img
 //         output image representation of graphs
 //   -indent
 //         indent JSON output
+//   -j int
+//         number of input files to process concurrently (default 1)
 //   -method string
 //         control flow recovery method (hammock, interval, pattern-independent)
 //         (default "hammock")
 //   -o string
-//         output path
+//         output path; a directory when more than one input is given
+//   -patterns string
+//         directory of user-defined primitive patterns (*.dot)
 //   -q    suppress non-error messages
 //   -steps
 //         output intermediate steps
@@ -36,35 +48,33 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mewkiz/pkg/pathutil"
 	"github.com/mewkiz/pkg/term"
 	"github.com/mewmew/lnp/pkg/cfa"
+	"github.com/mewmew/lnp/pkg/cfa/dataflow"
 	"github.com/mewmew/lnp/pkg/cfa/hammock"
 	"github.com/mewmew/lnp/pkg/cfa/interval"
+	"github.com/mewmew/lnp/pkg/cfa/pind"
 	"github.com/mewmew/lnp/pkg/cfa/primitive"
 	"github.com/mewmew/lnp/pkg/cfg"
 	"github.com/pkg/errors"
 	"gonum.org/v1/gonum/graph/encoding"
 )
 
-var (
-	// dbg is a logger which logs debug messages to standard error, prepending
-	// the "restructure:" prefix.
-	dbg = log.New(os.Stderr, term.MagentaBold("restructure:")+" ", 0)
-	// warn is a logger which logs warning messages to standard error, prepending
-	// the "restructure:" prefix.
-	warn = log.New(os.Stderr, term.RedBold("restructure:")+" ", 0)
-)
-
 func usage() {
 	const use = `
 Recover control flow primitives from control flow graphs (*.dot -> *.json).
 
 Usage:
 
-	restructure [OPTION]... [FILE.dot]
+	restructure [OPTION]... [FILE.dot]...
+	restructure [OPTION]... DIR
 
 Flags:
 `
@@ -75,51 +85,127 @@ Flags:
 func main() {
 	// Parse command line arguments.
 	var (
+		// analyze specifies a comma-separated list of dataflow analyses to
+		// run and dump as a sidecar JSON file (reach, dom-frontier, loops).
+		analyze string
 		// img specifies whether to output image representation of graphs.
 		img bool
 		// indent specifies whether to indent JSON output.
 		indent bool
+		// workers specifies the number of input files to process
+		// concurrently.
+		workers int
 		// method specifies the control flow recovery method (hammock, interval,
 		// pattern-independent).
 		method string
-		// output specifies the output path.
+		// output specifies the output path; a directory when more than one
+		// input is given.
 		output string
+		// patterns specifies a directory of user-defined primitive patterns
+		// (in Graphviz DOT format) to load in addition to the default
+		// library of primitives.
+		patterns string
 		// quiet specifies whether to suppress non-error messages.
 		quiet bool
 		// steps specifies whether to output intermediate steps.
 		steps bool
 	)
+	flag.StringVar(&analyze, "analyze", "", "comma-separated list of dataflow analyses to run (reach, dom-frontier, loops)")
 	flag.BoolVar(&img, "img", false, "output image representation of graphs")
 	flag.BoolVar(&indent, "indent", false, "indent JSON output")
+	flag.IntVar(&workers, "j", 1, "number of input files to process concurrently")
 	flag.StringVar(&method, "method", "hammock", "control flow recovery method (hammock, interval, pattern-independent)")
-	flag.StringVar(&output, "o", "", "output path")
+	flag.StringVar(&output, "o", "", "output path; a directory when more than one input is given")
+	flag.StringVar(&patterns, "patterns", "", "directory of user-defined primitive patterns (*.dot)")
 	flag.BoolVar(&quiet, "q", false, "suppress non-error messages")
 	flag.BoolVar(&steps, "steps", false, "output intermediate steps")
 	flag.Usage = usage
 	flag.Parse()
-	var dotPath string
-	switch flag.NArg() {
-	case 0:
-		// Parse DOT file from standard input.
-		dotPath = "-"
-	case 1:
-		dotPath = flag.Arg(0)
-	default:
-		flag.Usage()
-		os.Exit(1)
-	}
-	if quiet {
-		// Mute debug messages if `-q` is set.
-		dbg.SetOutput(ioutil.Discard)
+	if len(patterns) > 0 {
+		if err := hammock.LoadPatterns(patterns); err != nil {
+			log.Fatalf("%+v", err)
+		}
 	}
 
-	// Perform control flow analysis.
-	prims, err := restructure(dotPath, method, steps, img)
+	paths, err := dotPaths(flag.Args())
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
 
-	// Output primitives in JSON format.
+	// A single standard input or file target keeps the simple one-shot
+	// behaviour of writing the recovered primitives to `-o` (or standard
+	// output); multiple targets (several files, or a directory, which is
+	// expanded above into every *.dot file within, holding more than one) are
+	// processed as a batch, each producing its own "<name>.json" alongside a
+	// top-level manifest. A directory holding a single *.dot file is handled
+	// like that one file.
+	if len(paths) <= 1 {
+		dotPath := "-"
+		if len(paths) == 1 {
+			dotPath = paths[0]
+		}
+		runSingle(dotPath, method, analyze, output, steps, img, indent, quiet)
+		return
+	}
+	runBatch(paths, method, analyze, output, workers, steps, img, indent, quiet)
+}
+
+// dotPaths expands the given command line arguments into a flat list of DOT
+// file paths, recursively globbing "*.dot" under any argument that names a
+// directory. With no arguments, a single "-" sentinel is returned, denoting
+// standard input.
+func dotPaths(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{"-"}, nil
+	}
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			// Treat "-" (and any other unreadable path) as a literal input;
+			// parseCFGInto reports a descriptive error if it cannot be
+			// opened.
+			paths = append(paths, arg)
+			continue
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".dot") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return paths, nil
+}
+
+// runSingle recovers and outputs the control flow primitives of a single DOT
+// file (or standard input), preserving the original one-shot behaviour of
+// restructure: the primitives are written to output, or to standard output
+// if output is empty.
+func runSingle(dotPath, method, analyze, output string, steps, img, indent, quiet bool) {
+	dbg, warn := newLoggers("restructure", quiet)
+	result := restructure(job{
+		dotPath: dotPath,
+		method:  method,
+		analyze: analyze,
+		steps:   steps,
+		img:     img,
+		dbg:     dbg,
+		warn:    warn,
+	})
+	if result.err != nil {
+		log.Fatalf("%+v", result.err)
+	}
 	w := os.Stdout
 	if len(output) > 0 {
 		f, err := os.Create(output)
@@ -129,30 +215,208 @@ func main() {
 		defer f.Close()
 		w = f
 	}
-	if err := outputJSON(w, prims, indent); err != nil {
+	if err := outputJSON(w, result.prims, indent); err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
 
-// restructure attempts to recover the control flow primitives of a given
-// control flow graph.
-//
-// method specifies the control flow recovery method to use.
-//
-// steps specifies whether to record the intermediate control flow graphs at
-// each step. The returned list of primitives is ordered in the same sequence as
-// they were located.
-//
-// img specifies whether to output image representations of the intermediate
-// control flow graphs.
-func restructure(dotPath, method string, steps, img bool) ([]*primitive.Primitive, error) {
+// runBatch recovers and outputs the control flow primitives of every DOT
+// file in dotPaths, using a pool of workers goroutines running concurrently.
+// Each input produces its own "<name>.json" next to the source (or under
+// outdir, if non-empty), and a top-level manifest.json records, per input,
+// the chosen method, elapsed time, primitive count, and whether the
+// recovery was incomplete.
+func runBatch(dotPaths []string, method, analyze, outdir string, workers int, steps, img, indent, quiet bool) {
+	if workers < 1 {
+		workers = 1
+	}
+	if len(outdir) > 0 {
+		if err := os.MkdirAll(outdir, 0755); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	manifest := make([]*manifestEntry, len(dotPaths))
+	tracker := &outputTracker{}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, dotPath := range dotPaths {
+		wg.Add(1)
+		go func(i int, dotPath string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			manifest[i] = processOne(dotPath, method, analyze, outdir, steps, img, indent, quiet, tracker)
+		}(i, dotPath)
+	}
+	wg.Wait()
+
+	manifestPath := "manifest.json"
+	if len(outdir) > 0 {
+		manifestPath = filepath.Join(outdir, manifestPath)
+	}
+	buf, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	buf = append(buf, '\n')
+	if err := ioutil.WriteFile(manifestPath, buf, 0644); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// manifestEntry records the outcome of recovering the control flow
+// primitives of a single function, as reported in manifest.json.
+type manifestEntry struct {
+	// Path is the input DOT file.
+	Path string `json:"path"`
+	// Method is the control flow recovery method used.
+	Method string `json:"method"`
+	// Elapsed is the time taken to recover the primitives.
+	Elapsed time.Duration `json:"elapsed"`
+	// NumPrims is the number of primitives recovered.
+	NumPrims int `json:"num_prims"`
+	// Incomplete reports whether recovery returned cfa.ErrIncomplete.
+	Incomplete bool `json:"incomplete"`
+	// Err, if non-empty, reports a fatal error encountered while processing
+	// Path.
+	Err string `json:"err,omitempty"`
+}
+
+// outputTracker detects output path collisions across the concurrent jobs of
+// a batch, e.g. two inputs named foo.dot in different source directories
+// that would otherwise both be written to "outdir/foo.json".
+type outputTracker struct {
+	mu   sync.Mutex
+	seen map[string]string // output path -> input DOT path that claimed it
+}
+
+// claim registers dotPath as the owner of outPath, reporting the input that
+// claimed it first if outPath was already claimed.
+func (t *outputTracker) claim(outPath, dotPath string) (prior string, collided bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = make(map[string]string)
+	}
+	if prior, ok := t.seen[outPath]; ok {
+		return prior, true
+	}
+	t.seen[outPath] = dotPath
+	return "", false
+}
+
+// processOne recovers the control flow primitives of dotPath and writes them
+// to "<name>.json" next to the source (or under outdir), returning the
+// corresponding manifest entry. tracker detects output path collisions with
+// other jobs of the same batch.
+func processOne(dotPath, method, analyze, outdir string, steps, img, indent, quiet bool, tracker *outputTracker) *manifestEntry {
+	prefix := fmt.Sprintf("restructure[%s]", pathutil.TrimExt(filepath.Base(dotPath)))
+	dbg, warn := newLoggers(prefix, quiet)
+	start := time.Now()
+	result := restructure(job{
+		dotPath: dotPath,
+		method:  method,
+		analyze: analyze,
+		steps:   steps,
+		img:     img,
+		dbg:     dbg,
+		warn:    warn,
+	})
+	entry := &manifestEntry{
+		Path:       dotPath,
+		Method:     method,
+		Elapsed:    time.Since(start),
+		Incomplete: result.incomplete,
+	}
+	if result.err != nil {
+		warn.Printf("%+v", result.err)
+		entry.Err = result.err.Error()
+		return entry
+	}
+	entry.NumPrims = len(result.prims)
+
+	outPath := pathutil.TrimExt(dotPath) + ".json"
+	if len(outdir) > 0 {
+		outPath = filepath.Join(outdir, filepath.Base(outPath))
+		if prior, collided := tracker.claim(outPath, dotPath); collided {
+			err := errors.Errorf("output path %q for %q collides with %q; refusing to overwrite", outPath, dotPath, prior)
+			warn.Printf("%+v", err)
+			entry.Err = err.Error()
+			return entry
+		}
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		warn.Printf("%+v", err)
+		entry.Err = err.Error()
+		return entry
+	}
+	defer f.Close()
+	if err := outputJSON(f, result.prims, indent); err != nil {
+		warn.Printf("%+v", err)
+		entry.Err = err.Error()
+	}
+	return entry
+}
+
+// newLoggers returns a pair of debug and warning loggers prefixed with
+// prefix, so that the interleaved output of concurrent jobs remains
+// attributable and readable. If quiet is set, debug messages are discarded.
+func newLoggers(prefix string, quiet bool) (dbg, warn *log.Logger) {
+	dbg = log.New(os.Stderr, term.MagentaBold(prefix+":")+" ", 0)
+	warn = log.New(os.Stderr, term.RedBold(prefix+":")+" ", 0)
+	if quiet {
+		dbg.SetOutput(ioutil.Discard)
+	}
+	return dbg, warn
+}
+
+// job bundles the input and options of a single control flow recovery task,
+// including the per-job loggers used to keep concurrent output readable.
+type job struct {
+	// dotPath is the input DOT file, or "-" for standard input.
+	dotPath string
+	// method is the control flow recovery method to use.
+	method string
+	// analyze is a comma-separated list of dataflow analyses (reach,
+	// dom-frontier, loops) to run and dump as a sidecar JSON file next to the
+	// primitive output.
+	analyze string
+	// steps specifies whether to record the intermediate control flow graphs
+	// at each step.
+	steps bool
+	// img specifies whether to output image representations of the
+	// intermediate control flow graphs.
+	img bool
+	// dbg and warn are the loggers used to report debug and warning
+	// messages for this job.
+	dbg, warn *log.Logger
+}
+
+// result holds the outcome of a single control flow recovery task.
+type result struct {
+	// prims is the list of recovered primitives, ordered in the same
+	// sequence as they were located.
+	prims []*primitive.Primitive
+	// incomplete reports whether the control flow graph could not be fully
+	// reduced (cfa.ErrIncomplete).
+	incomplete bool
+	// err holds a fatal error encountered while processing the job, if any.
+	err error
+}
+
+// restructure attempts to recover the control flow primitives of the control
+// flow graph named by j.dotPath, using the method, analyses and loggers
+// described by j.
+func restructure(j job) *result {
 	var stepPrefix string
-	switch dotPath {
+	switch j.dotPath {
 	case "-":
 		// Use "stdin" prefix for intermediate step files.
 		stepPrefix = "stdin"
 	default:
-		stepPrefix = pathutil.TrimExt(dotPath)
+		stepPrefix = pathutil.TrimExt(j.dotPath)
 	}
 	// Output intermediate steps in Graphviz DOT format.
 	var (
@@ -160,87 +424,121 @@ func restructure(dotPath, method string, steps, img bool) ([]*primitive.Primitiv
 		after  func(g cfa.Graph, prim *primitive.Primitive)
 	)
 	step := 1
-	if steps {
+	if j.steps {
 		before = func(g cfa.Graph, prim *primitive.Primitive) {
 			data := []byte(dotBeforeMerge(g, prim))
-			dbg.Printf("located primitive:\n%s", prim)
+			j.dbg.Printf("located primitive:\n%s", prim)
 			beforePath := fmt.Sprintf("%s_%04da.dot", stepPrefix, step)
-			dbg.Printf("creating file %q", beforePath)
+			j.dbg.Printf("creating file %q", beforePath)
 			if err := ioutil.WriteFile(beforePath, data, 0644); err != nil {
-				warn.Printf("unable to create %q; %v", beforePath, err)
+				j.warn.Printf("unable to create %q; %v", beforePath, err)
 			}
 			// Store an image representation of the intermediate CFG if `-img` is
 			// set.
-			if img {
-				if err := outputImg(beforePath); err != nil {
-					warn.Println(err)
+			if j.img {
+				if err := outputImg(beforePath, j.dbg); err != nil {
+					j.warn.Println(err)
 				}
 			}
 		}
 		after = func(g cfa.Graph, prim *primitive.Primitive) {
 			data := []byte(dotAfterMerge(g, prim))
 			afterPath := fmt.Sprintf("%s_%04db.dot", stepPrefix, step)
-			dbg.Printf("creating file %q", afterPath)
+			j.dbg.Printf("creating file %q", afterPath)
 			if err := ioutil.WriteFile(afterPath, data, 0644); err != nil {
-				warn.Printf("unable to create %q; %v", afterPath, err)
+				j.warn.Printf("unable to create %q; %v", afterPath, err)
 			}
 			// Store an image representation of the intermediate CFG if `-img` is
 			// set.
-			if img {
-				if err := outputImg(afterPath); err != nil {
-					warn.Println(err)
+			if j.img {
+				if err := outputImg(afterPath, j.dbg); err != nil {
+					j.warn.Println(err)
 				}
 			}
 			step++
 		}
 	}
 	// Recovery control flow primitives.
-	switch method {
+	switch j.method {
 	case "hammock":
 		// Parse control flow graph.
 		g := cfg.NewGraph()
-		if err := parseCFGInto(dotPath, g); err != nil {
-			return nil, errors.WithStack(err)
+		if err := parseCFGInto(j.dotPath, g); err != nil {
+			return &result{err: errors.WithStack(err)}
+		}
+		if len(j.analyze) > 0 {
+			if err := runAnalyses(g, j.analyze, stepPrefix, j.dbg); err != nil {
+				return &result{err: errors.WithStack(err)}
+			}
 		}
+		// Match user-supplied patterns (if any were loaded with -patterns)
+		// ahead of the built-in matchers, so they take precedence over, and
+		// extend, the default library of primitives.
+		patPrims := hammock.MatchPatterns(g, before, after)
 		// Perform control flow analysis.
 		prims, err := hammock.Analyze(g, before, after)
+		prims = append(patPrims, prims...)
 		if err != nil {
-			if errors.Cause(err) == cfa.ErrIncomplete {
-				warn.Printf("warning: %v", err)
-			} else {
-				return nil, errors.WithStack(err)
+			if errors.Cause(err) != cfa.ErrIncomplete {
+				return &result{err: errors.WithStack(err)}
 			}
+			return &result{prims: prims, incomplete: true}
 		}
-		return prims, nil
+		return &result{prims: prims}
 	case "interval":
 		// Parse control flow graph.
 		g := interval.NewGraph()
-		if err := parseCFGInto(dotPath, g); err != nil {
-			return nil, errors.WithStack(err)
+		if err := parseCFGInto(j.dotPath, g); err != nil {
+			return &result{err: errors.WithStack(err)}
+		}
+		if len(j.analyze) > 0 {
+			if err := runAnalyses(g, j.analyze, stepPrefix, j.dbg); err != nil {
+				return &result{err: errors.WithStack(err)}
+			}
 		}
 		// Output derived sequence of graphs.
-		if steps {
+		if j.steps {
 			Gs, IIs := interval.DerivedSequence(g)
 			for i, g := range Gs {
 				name := fmt.Sprintf("G_%d.dot", i+1)
 				if err := ioutil.WriteFile(name, []byte(g.String()), 0644); err != nil {
-					return nil, errors.WithStack(err)
+					return &result{err: errors.WithStack(err)}
 				}
 			}
 			for i, Is := range IIs {
 				for j, I := range Is {
 					name := fmt.Sprintf("I_%d_%d.dot", i+1, j+1)
 					if err := ioutil.WriteFile(name, []byte(I.String()), 0644); err != nil {
-						return nil, errors.WithStack(err)
+						return &result{err: errors.WithStack(err)}
 					}
 				}
 			}
 		}
 		// Perform control flow analysis.
 		prims := interval.Analyze(g, before, after)
-		return prims, nil
+		return &result{prims: prims}
+	case "pattern-independent":
+		// Parse control flow graph.
+		g := interval.NewGraph()
+		if err := parseCFGInto(j.dotPath, g); err != nil {
+			return &result{err: errors.WithStack(err)}
+		}
+		if len(j.analyze) > 0 {
+			if err := runAnalyses(g, j.analyze, stepPrefix, j.dbg); err != nil {
+				return &result{err: errors.WithStack(err)}
+			}
+		}
+		// Perform control flow analysis.
+		prims, err := pind.Analyze(g, before, after)
+		if err != nil {
+			if errors.Cause(err) != cfa.ErrIncomplete {
+				return &result{err: errors.WithStack(err)}
+			}
+			return &result{prims: prims, incomplete: true}
+		}
+		return &result{prims: prims}
 	default:
-		panic(fmt.Errorf("support for control flow recovery method %q not yet implemented", method))
+		panic(fmt.Errorf("support for control flow recovery method %q not yet implemented", j.method))
 	}
 }
 
@@ -320,6 +618,94 @@ func parseCFGInto(dotPath string, dst cfa.Graph) error {
 	}
 }
 
+// runAnalyses runs the comma-separated list of dataflow analyses named in
+// analyze (reach, dom-frontier, loops) over g, writing the per-node facts of
+// each as a sidecar JSON file "<stepPrefix>.analysis.json". "reach" also
+// prunes unreachable nodes from g in place, so control flow recovery never
+// sees dead code.
+func runAnalyses(g cfa.Graph, analyze, stepPrefix string, dbg *log.Logger) error {
+	entry := findEntry(g)
+	dom := cfg.NewDominatorTree(g, entry)
+	facts := make(map[string]interface{})
+	for _, name := range strings.Split(analyze, ",") {
+		switch name {
+		case "reach":
+			reachable := dataflow.Prune(g, entry)
+			m := make(map[string]bool, len(reachable))
+			for id, ok := range reachable {
+				if n, ok := g.Node(id).(cfa.Node); ok {
+					m[n.DOTID()] = ok
+				}
+			}
+			facts["reach"] = m
+		case "dom-frontier":
+			df := dataflow.DominanceFrontiers(g, dom)
+			m := make(map[string][]string, len(df))
+			for id, nodes := range df {
+				n, ok := g.Node(id).(cfa.Node)
+				if !ok {
+					continue
+				}
+				labels := make([]string, len(nodes))
+				for i, dn := range nodes {
+					labels[i] = dn.DOTID()
+				}
+				m[n.DOTID()] = labels
+			}
+			facts["dom-frontier"] = m
+		case "loops":
+			loops := dataflow.NaturalLoops(g, dom)
+			type loopFact struct {
+				Header  string   `json:"header"`
+				Latches []string `json:"latches"`
+				Nodes   []string `json:"nodes"`
+			}
+			lfs := make([]loopFact, len(loops))
+			for i, loop := range loops {
+				lf := loopFact{Header: loop.Header.DOTID()}
+				for _, n := range loop.Latches {
+					lf.Latches = append(lf.Latches, n.DOTID())
+				}
+				for _, n := range loop.Nodes {
+					lf.Nodes = append(lf.Nodes, n.DOTID())
+				}
+				lfs[i] = lf
+			}
+			facts["loops"] = lfs
+		default:
+			return errors.Errorf("unknown dataflow analysis %q", name)
+		}
+	}
+	buf, err := json.MarshalIndent(facts, "", "\t")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	path := stepPrefix + ".analysis.json"
+	dbg.Printf("creating file %q", path)
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// findEntry returns the entry node of g, the node with no incoming edges. If
+// no such node exists (e.g. every node lies on a cycle), the first node
+// encountered is returned.
+func findEntry(g cfa.Graph) cfa.Node {
+	nodes := g.Nodes()
+	var first cfa.Node
+	for nodes.Next() {
+		n := nodes.Node().(cfa.Node)
+		if first == nil {
+			first = n
+		}
+		if g.To(n.ID()).Len() == 0 {
+			return n
+		}
+	}
+	return first
+}
+
 // outputJSON outputs the primitives in JSON format with optional indentation,
 // writing to w.
 func outputJSON(w io.Writer, prims []*primitive.Primitive, indent bool) error {
@@ -344,7 +730,7 @@ func outputJSON(w io.Writer, prims []*primitive.Primitive, indent bool) error {
 }
 
 // outputImg outputs an image representation of the given Graphviz DOT file.
-func outputImg(dotPath string) error {
+func outputImg(dotPath string, dbg *log.Logger) error {
 	pngPath := pathutil.TrimExt(dotPath) + ".png"
 	dbg.Printf("creating file %q", pngPath)
 	cmd := exec.Command("dot", "-Tpng", "-o", pngPath, dotPath)
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+// TestOutputTrackerClaim verifies that outputTracker detects the second of
+// two inputs with the same basename (e.g. pkgA/foo.dot and pkgB/foo.dot)
+// claiming the same "-o outdir" output path, rather than silently letting the
+// second overwrite the first.
+func TestOutputTrackerClaim(t *testing.T) {
+	tracker := &outputTracker{}
+
+	if _, collided := tracker.claim("outdir/foo.json", "pkgA/foo.dot"); collided {
+		t.Fatalf("first claim of outdir/foo.json unexpectedly collided")
+	}
+	prior, collided := tracker.claim("outdir/foo.json", "pkgB/foo.dot")
+	if !collided {
+		t.Fatalf("second claim of outdir/foo.json did not collide")
+	}
+	if prior != "pkgA/foo.dot" {
+		t.Errorf("got prior claimant %q, want %q", prior, "pkgA/foo.dot")
+	}
+
+	if _, collided := tracker.claim("outdir/bar.json", "pkgA/bar.dot"); collided {
+		t.Fatalf("claim of a distinct output path unexpectedly collided")
+	}
+}
@@ -0,0 +1,203 @@
+// Package dataflow provides a generic, polymorphic dataflow analysis engine
+// over control flow graphs, parameterized by a lattice, a transfer function
+// and a meet operator. Concrete analyses are expressed by implementing
+// Lattice and Transfer and handing them to a Solver, as the reachability
+// analysis (Reachable, Prune) does. Dominance frontiers and natural loops are
+// also provided; they are computed directly from a dominator tree rather than
+// through the Solver.
+package dataflow
+
+import (
+	"github.com/mewmew/lnp/pkg/cfa"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// Fact represents an analysis-specific dataflow fact associated with a node
+// of the control flow graph.
+type Fact interface{}
+
+// Lattice defines the set of dataflow facts an analysis operates over, and
+// how two facts are joined (merged) at control flow confluence points.
+type Lattice interface {
+	// Bottom returns the bottom element of the lattice, used to initialize
+	// the facts of every node before the analysis reaches fixpoint.
+	Bottom() Fact
+	// Join returns the least upper bound of a and b.
+	Join(a, b Fact) Fact
+	// Equal reports whether a and b represent the same fact, used to detect
+	// fixpoint.
+	Equal(a, b Fact) bool
+}
+
+// Transfer defines how a dataflow fact is transformed as control flow passes
+// through a node, and optionally through an edge.
+type Transfer interface {
+	// Node returns the fact that holds after (forward analyses) or before
+	// (backward analyses) n, given the fact in that holds on entry to
+	// (forward) or exit from (backward) n.
+	Node(n cfa.Node, in Fact) Fact
+}
+
+// EdgeTransfer is implemented by analyses that refine facts along individual
+// edges of the control flow graph, e.g. to model the effect of a branch
+// condition.
+type EdgeTransfer interface {
+	// Edge returns the fact that holds on the edge from -> to, given the
+	// fact f produced at the from node.
+	Edge(from, to cfa.Node, f Fact) Fact
+}
+
+// Direction specifies the direction in which a Solver propagates facts.
+type Direction uint8
+
+const (
+	// Forward propagates facts from predecessors to successors.
+	Forward Direction = iota
+	// Backward propagates facts from successors to predecessors.
+	Backward
+)
+
+// Solver computes the fixpoint solution of a dataflow analysis over a
+// control flow graph, iterating in reverse-postorder (forward analyses) or
+// postorder (backward analyses) with a worklist keyed on node ID.
+type Solver struct {
+	// Lattice defines the dataflow facts and how they are joined.
+	Lattice Lattice
+	// Transfer defines how facts are transformed across nodes (and,
+	// optionally, edges).
+	Transfer Transfer
+	// Dir specifies the direction of propagation.
+	Dir Direction
+}
+
+// NewSolver returns a new dataflow solver for the given lattice and transfer
+// function, propagating facts in the given direction.
+func NewSolver(lattice Lattice, transfer Transfer, dir Direction) *Solver {
+	return &Solver{
+		Lattice:  lattice,
+		Transfer: transfer,
+		Dir:      dir,
+	}
+}
+
+// Solve computes the fixpoint solution of the dataflow analysis over g,
+// returning the fact that holds on entry to each node: for forward analyses
+// the join of its predecessors' facts, for backward analyses the fact
+// Transfer.Node produces from the join of its successors' facts.
+func (s *Solver) Solve(g cfa.Graph) map[int64]Fact {
+	nodes := graph.NodesOf(g.Nodes())
+	order := s.order(g, nodes)
+
+	in := make(map[int64]Fact, len(nodes))
+	out := make(map[int64]Fact, len(nodes))
+	for _, n := range nodes {
+		in[n.ID()] = s.Lattice.Bottom()
+		out[n.ID()] = s.Lattice.Bottom()
+	}
+
+	preds := func(n graph.Node) graph.Nodes { return g.To(n.ID()) }
+	succs := func(n graph.Node) graph.Nodes { return g.From(n.ID()) }
+	if s.Dir == Backward {
+		preds, succs = succs, preds
+	}
+
+	worklist := make([]graph.Node, len(order))
+	copy(worklist, order)
+	onList := make(map[int64]bool, len(order))
+	for _, n := range worklist {
+		onList[n.ID()] = true
+	}
+
+	for len(worklist) > 0 {
+		n := worklist[0]
+		worklist = worklist[1:]
+		onList[n.ID()] = false
+
+		// Join facts flowing in from every predecessor (in the direction of
+		// propagation), optionally refined by an edge transfer function.
+		fact := s.Lattice.Bottom()
+		ps := preds(n)
+		for ps.Next() {
+			p := ps.Node()
+			pf := out[p.ID()]
+			if et, ok := s.Transfer.(EdgeTransfer); ok {
+				if s.Dir == Forward {
+					pf = et.Edge(asNode(p), asNode(n), pf)
+				} else {
+					pf = et.Edge(asNode(n), asNode(p), pf)
+				}
+			}
+			fact = s.Lattice.Join(fact, pf)
+		}
+		in[n.ID()] = fact
+
+		newOut := s.Transfer.Node(asNode(n), fact)
+		if !s.Lattice.Equal(newOut, out[n.ID()]) {
+			out[n.ID()] = newOut
+			ss := succs(n)
+			for ss.Next() {
+				succ := ss.Node()
+				if !onList[succ.ID()] {
+					worklist = append(worklist, succ)
+					onList[succ.ID()] = true
+				}
+			}
+		}
+	}
+
+	if s.Dir == Forward {
+		return in
+	}
+	return out
+}
+
+// order returns nodes sorted in reverse-postorder (forward analyses) or
+// postorder (backward analyses) of a depth-first traversal of g.
+func (s *Solver) order(g cfa.Graph, nodes []graph.Node) []graph.Node {
+	post, err := topo.Sort(g)
+	if err != nil {
+		// g contains a cycle (true of essentially every real CFG with a
+		// loop); topo.Sort still returns its best-effort order, marking the
+		// position of each cyclic component with a nil placeholder and
+		// listing the component's members in the returned topo.Unorderable
+		// error. Splice the members back in at their placeholders, which is
+		// sufficient to seed the worklist, rather than falling back to the
+		// arbitrary node order.
+		if cycles, ok := err.(topo.Unorderable); ok {
+			var spliced []graph.Node
+			for _, n := range post {
+				if n == nil && len(cycles) > 0 {
+					spliced = append(spliced, cycles[0]...)
+					cycles = cycles[1:]
+					continue
+				}
+				spliced = append(spliced, n)
+			}
+			post = spliced
+		}
+		if len(post) != len(nodes) {
+			post = nodes
+		}
+	}
+	if s.Dir == Forward {
+		// topo.Sort already orders predecessors before successors, i.e.
+		// reverse-postorder.
+		return post
+	}
+	reversed := make([]graph.Node, len(post))
+	for i, n := range post {
+		reversed[len(post)-1-i] = n
+	}
+	return reversed
+}
+
+// asNode converts a graph.Node to a cfa.Node, panicking if n does not
+// implement cfa.Node; every node produced by pkg/cfg does.
+func asNode(n graph.Node) cfa.Node {
+	cn, ok := n.(cfa.Node)
+	if !ok {
+		panic("dataflow: node does not implement cfa.Node")
+	}
+	return cn
+}
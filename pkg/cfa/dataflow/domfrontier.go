@@ -0,0 +1,47 @@
+package dataflow
+
+import (
+	"github.com/mewmew/lnp/pkg/cfa"
+	"github.com/mewmew/lnp/pkg/cfg"
+	"gonum.org/v1/gonum/graph"
+)
+
+// DominanceFrontiers computes the dominance frontier of every node of g from
+// its dominator tree dom, using the algorithm of Cytron et al. The result
+// accelerates loop-header detection in hammock.FindPreLoop and friends,
+// since a node's dominance frontier captures exactly the join points its
+// dominance does not extend to.
+func DominanceFrontiers(g cfa.Graph, dom cfg.DominatorTree) map[int64][]cfa.Node {
+	df := make(map[int64][]cfa.Node)
+	seen := make(map[int64]map[int64]bool)
+	nodes := graph.NodesOf(g.Nodes())
+	for _, node := range nodes {
+		n := node.(cfa.Node)
+		preds := graph.NodesOf(g.To(n.ID()))
+		if len(preds) < 2 {
+			continue
+		}
+		idom, ok := dom.IDom(n)
+		if !ok {
+			continue
+		}
+		for _, pred := range preds {
+			runner := pred.(cfa.Node)
+			for runner.ID() != idom.ID() {
+				if seen[runner.ID()] == nil {
+					seen[runner.ID()] = make(map[int64]bool)
+				}
+				if !seen[runner.ID()][n.ID()] {
+					seen[runner.ID()][n.ID()] = true
+					df[runner.ID()] = append(df[runner.ID()], n)
+				}
+				next, ok := dom.IDom(runner)
+				if !ok {
+					break
+				}
+				runner = next
+			}
+		}
+	}
+	return df
+}
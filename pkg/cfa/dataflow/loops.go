@@ -0,0 +1,90 @@
+package dataflow
+
+import (
+	"github.com/mewmew/lnp/pkg/cfa"
+	"github.com/mewmew/lnp/pkg/cfg"
+	"gonum.org/v1/gonum/graph"
+)
+
+// Loop represents a natural loop discovered through back-edge
+// identification, used by the interval method to cross-check its own loop
+// classification.
+type Loop struct {
+	// Header is the loop header, the single entry node of the loop.
+	Header cfa.Node
+	// Latches holds the source nodes of every back edge targeting Header.
+	Latches []cfa.Node
+	// Nodes holds every node of the loop body, including Header and every
+	// node in Latches.
+	Nodes []cfa.Node
+}
+
+// NaturalLoops discovers the natural loops of g from its dominator tree dom.
+// An edge (n -> h) is a back edge, and h the header of a natural loop, if h
+// dominates n. Loops that share a header are merged into a single Loop, as
+// is standard for irreducible back-edge sets sharing a common header.
+func NaturalLoops(g cfa.Graph, dom cfg.DominatorTree) []*Loop {
+	loopsByHeader := make(map[int64]*Loop)
+	var headers []cfa.Node
+
+	nodes := graph.NodesOf(g.Nodes())
+	for _, node := range nodes {
+		n := node.(cfa.Node)
+		succs := graph.NodesOf(g.From(n.ID()))
+		for _, succ := range succs {
+			h := succ.(cfa.Node)
+			if !dom.Dominates(h, n) {
+				continue
+			}
+			// (n -> h) is a back edge; h is a loop header and n a latch.
+			loop, ok := loopsByHeader[h.ID()]
+			if !ok {
+				loop = &Loop{Header: h}
+				loopsByHeader[h.ID()] = loop
+				headers = append(headers, h)
+			}
+			loop.Latches = append(loop.Latches, n)
+		}
+	}
+
+	var loops []*Loop
+	for _, h := range headers {
+		loop := loopsByHeader[h.ID()]
+		loop.Nodes = loopBody(g, loop.Header, loop.Latches)
+		loops = append(loops, loop)
+	}
+	return loops
+}
+
+// loopBody returns the set of nodes belonging to the natural loop with the
+// given header and latches, computed by walking backwards from each latch
+// until the header is reached.
+func loopBody(g cfa.Graph, header cfa.Node, latches []cfa.Node) []cfa.Node {
+	inLoop := map[int64]bool{header.ID(): true}
+	var order []cfa.Node
+	order = append(order, header)
+
+	var worklist []cfa.Node
+	for _, latch := range latches {
+		if !inLoop[latch.ID()] {
+			inLoop[latch.ID()] = true
+			order = append(order, latch)
+			worklist = append(worklist, latch)
+		}
+	}
+	for len(worklist) > 0 {
+		n := worklist[0]
+		worklist = worklist[1:]
+		preds := graph.NodesOf(g.To(n.ID()))
+		for _, pred := range preds {
+			p := pred.(cfa.Node)
+			if inLoop[p.ID()] {
+				continue
+			}
+			inLoop[p.ID()] = true
+			order = append(order, p)
+			worklist = append(worklist, p)
+		}
+	}
+	return order
+}
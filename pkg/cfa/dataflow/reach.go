@@ -0,0 +1,69 @@
+package dataflow
+
+import (
+	"github.com/mewmew/lnp/pkg/cfa"
+	"gonum.org/v1/gonum/graph"
+)
+
+// reachFact records whether a node is reachable from the entry node of the
+// control flow graph.
+type reachFact bool
+
+// reachLattice is the Lattice of the reachability analysis; Join is boolean
+// OR, so a node is reachable as soon as any predecessor is.
+type reachLattice struct{}
+
+func (reachLattice) Bottom() Fact         { return reachFact(false) }
+func (reachLattice) Join(a, b Fact) Fact  { return a.(reachFact) || b.(reachFact) }
+func (reachLattice) Equal(a, b Fact) bool { return a.(reachFact) == b.(reachFact) }
+
+// reachTransfer is the Transfer of the reachability analysis; every node
+// that receives a reachable fact on entry is itself reachable.
+type reachTransfer struct {
+	entry cfa.Node
+}
+
+func (t reachTransfer) Node(n cfa.Node, in Fact) Fact {
+	if n.ID() == t.entry.ID() {
+		return reachFact(true)
+	}
+	return in
+}
+
+// Reachable computes the set of nodes of g reachable from entry.
+func Reachable(g cfa.Graph, entry cfa.Node) map[int64]bool {
+	solver := NewSolver(reachLattice{}, reachTransfer{entry: entry}, Forward)
+	facts := solver.Solve(g)
+	reachable := make(map[int64]bool, len(facts))
+	for id, f := range facts {
+		if f.(reachFact) || id == entry.ID() {
+			reachable[id] = true
+		}
+	}
+	return reachable
+}
+
+// nodeRemover is implemented by control flow graphs that support removing a
+// node (and its incident edges) by ID, mirroring gonum/graph.NodeRemover.
+type nodeRemover interface {
+	RemoveNode(id int64)
+}
+
+// Prune removes every node of g not reachable from entry, along with its
+// incident edges, so restructure can drop dead code before control flow
+// recovery. It returns the same reachability set as Reachable. If g does not
+// implement RemoveNode (as the cfg, interval and hammock graph types all do),
+// Prune leaves g untouched and simply returns the reachability set.
+func Prune(g cfa.Graph, entry cfa.Node) map[int64]bool {
+	reachable := Reachable(g, entry)
+	remover, ok := g.(nodeRemover)
+	if !ok {
+		return reachable
+	}
+	for _, n := range graph.NodesOf(g.Nodes()) {
+		if !reachable[n.ID()] {
+			remover.RemoveNode(n.ID())
+		}
+	}
+	return reachable
+}
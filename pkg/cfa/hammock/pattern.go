@@ -0,0 +1,272 @@
+package hammock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/mewmew/lnp/pkg/cfa"
+	"github.com/mewmew/lnp/pkg/cfa/primitive"
+	"github.com/pkg/errors"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/multi"
+)
+
+// patterns holds the user-supplied primitives registered through
+// LoadPatterns, in addition to the default library of primitives matched by
+// Analyze (if, if_else, pre_loop, ...).
+var patterns []*SubGraph
+
+// SubGraph represents a user-defined control flow primitive pattern, loaded
+// from a Graphviz DOT file with "entry" and "exit" label attributes marking
+// the boundary nodes of the pattern.
+type SubGraph struct {
+	// Name of the primitive, taken from the digraph identifier of the DOT
+	// file (e.g. `digraph switch_fallthrough { ... }`).
+	Name string
+	// Graph holding the pattern to match against candidate control flow
+	// graphs.
+	Graph graph.Directed
+	// Entry is the DOT ID of the pattern node marked with an "entry" label
+	// attribute.
+	Entry string
+	// Exit is the DOT ID of the pattern node marked with an "exit" label
+	// attribute.
+	Exit string
+}
+
+// LoadPatterns loads the user-defined primitive patterns from the *.dot files
+// of dir, registering each as an addition to the default library of
+// primitives matched by Analyze.
+//
+// Each DOT file is expected to contain a single digraph whose identifier
+// names the primitive, and whose entry and exit nodes are marked with
+// "entry" and "exit" label attributes, e.g.:
+//
+//	digraph switch_fallthrough {
+//	   entry [label="entry"]
+//	   exit [label="exit"]
+//	   entry -> exit
+//	}
+func LoadPatterns(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.dot"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, path := range paths {
+		pattern, err := parsePattern(path)
+		if err != nil {
+			return errors.WithMessagef(err, "unable to parse pattern %q", path)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return nil
+}
+
+// parsePattern parses the DOT file at path into a SubGraph pattern.
+func parsePattern(path string) (*SubGraph, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	g := &patternGraph{DirectedGraph: multi.NewDirectedGraph()}
+	if err := dot.Unmarshal(buf, g); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pattern := &SubGraph{
+		Name:  g.id,
+		Graph: g,
+	}
+	nodes := g.Nodes()
+	for nodes.Next() {
+		n, ok := nodes.Node().(dotNode)
+		if !ok {
+			continue
+		}
+		switch n.Attribute("label") {
+		case "entry":
+			pattern.Entry = n.DOTID()
+		case "exit":
+			pattern.Exit = n.DOTID()
+		}
+	}
+	if pattern.Entry == "" || pattern.Exit == "" {
+		return nil, errors.Errorf("pattern %q missing entry or exit label attribute", path)
+	}
+	return pattern, nil
+}
+
+// dotNode is implemented by graph nodes which expose both a DOT ID and
+// Graphviz DOT attributes.
+type dotNode interface {
+	graph.Node
+	DOTID() string
+	Attribute(key string) string
+}
+
+// patternGraph wraps a multi.DirectedGraph to record the digraph identifier
+// (the pattern name) encountered while unmarshalling a DOT file.
+type patternGraph struct {
+	*multi.DirectedGraph
+	id string
+}
+
+// DOTID returns the digraph identifier of g.
+func (g *patternGraph) DOTID() string { return g.id }
+
+// SetDOTID sets the digraph identifier of g, invoked by dot.Unmarshal with
+// the name of the parsed digraph.
+func (g *patternGraph) SetDOTID(id string) { g.id = id }
+
+// MatchPatterns repeatedly matches the patterns registered through
+// LoadPatterns against g, merging each match into a single node (invoking
+// before and after around the merge, in the same manner as Analyze) until no
+// further pattern matches. Callers run MatchPatterns ahead of Analyze's
+// built-in matchers (findIfPrim, findPreLoopPrim, ...), so that user-supplied
+// patterns take precedence over, and extend, the default library of
+// primitives; see cmd/restructure2's restructure for the call site.
+func MatchPatterns(g cfa.Graph, before, after func(g cfa.Graph, prim *primitive.Primitive)) []*primitive.Primitive {
+	var prims []*primitive.Primitive
+	for {
+		prim, ok := TryPatterns(g)
+		if !ok {
+			return prims
+		}
+		if before != nil {
+			before(g, prim)
+		}
+		g.Merge(prim)
+		if after != nil {
+			after(g, prim)
+		}
+		prims = append(prims, prim)
+	}
+}
+
+// TryPatterns attempts to match each pattern registered through LoadPatterns
+// against g, returning the first primitive found via subgraph isomorphism,
+// and a boolean indicating success. TryPatterns itself performs no merging;
+// MatchPatterns drives it in a loop, merging each match before trying again.
+func TryPatterns(g cfa.Graph) (*primitive.Primitive, bool) {
+	for _, pattern := range patterns {
+		mapping, ok := isomorphism(pattern.Graph, g)
+		if !ok {
+			continue
+		}
+		nodes := make(map[string]string, len(mapping))
+		for patLabel, n := range mapping {
+			nodes[patLabel] = label(n)
+		}
+		return &primitive.Primitive{
+			Prim:  pattern.Name,
+			Nodes: nodes,
+			Entry: nodes[pattern.Entry],
+			Exit:  nodes[pattern.Exit],
+		}, true
+	}
+	return nil, false
+}
+
+// isomorphism attempts to find a subgraph of host isomorphic to pattern,
+// using a VF2-style candidate-pair search, returning a mapping from pattern
+// node DOT ID to host node, and a boolean indicating success.
+func isomorphism(pattern graph.Directed, host cfa.Graph) (map[string]graph.Node, bool) {
+	patNodes := graph.NodesOf(pattern.Nodes())
+	mapping := make(map[int64]graph.Node, len(patNodes))
+	used := make(map[int64]bool, len(patNodes))
+	if !extendMapping(pattern, host, patNodes, 0, mapping, used) {
+		return nil, false
+	}
+	result := make(map[string]graph.Node, len(mapping))
+	for _, patNode := range patNodes {
+		n, ok := patNode.(dotNode)
+		if !ok {
+			continue
+		}
+		result[n.DOTID()] = mapping[patNode.ID()]
+	}
+	return result, true
+}
+
+// extendMapping recursively extends mapping with a candidate host node for
+// patNodes[i], backtracking on failure, until every pattern node has been
+// mapped to a distinct host node that preserves adjacency.
+func extendMapping(pattern graph.Directed, host cfa.Graph, patNodes []graph.Node, i int, mapping map[int64]graph.Node, used map[int64]bool) bool {
+	if i == len(patNodes) {
+		return true
+	}
+	patNode := patNodes[i]
+	hostNodes := graph.NodesOf(host.Nodes())
+	for _, candidate := range hostNodes {
+		if used[candidate.ID()] {
+			continue
+		}
+		if !feasible(pattern, host, patNode, candidate, mapping) {
+			continue
+		}
+		mapping[patNode.ID()] = candidate
+		used[candidate.ID()] = true
+		if extendMapping(pattern, host, patNodes, i+1, mapping, used) {
+			return true
+		}
+		delete(mapping, patNode.ID())
+		delete(used, candidate.ID())
+	}
+	return false
+}
+
+// feasible reports whether candidate is a consistent match for patNode, i.e.
+// candidate has exactly the in/out degree patNode declares, every
+// already-mapped predecessor/successor of patNode in the pattern has a
+// corresponding edge to/from candidate in the host graph, and candidate has
+// no edge to/from an already-mapped host node that the pattern does not
+// itself declare. Together these make this an induced subgraph isomorphism
+// rather than mere subgraph monomorphism: without them a host region with an
+// extra edge (a third branch, a fallthrough to a node outside the pattern,
+// or between two nodes the pattern doesn't model) would still "match" and get
+// silently merged, discarding the extra edge and misclassifying the real
+// shape.
+func feasible(pattern graph.Directed, host cfa.Graph, patNode, candidate graph.Node, mapping map[int64]graph.Node) bool {
+	if pattern.From(patNode.ID()).Len() != host.From(candidate.ID()).Len() {
+		return false
+	}
+	if pattern.To(patNode.ID()).Len() != host.To(candidate.ID()).Len() {
+		return false
+	}
+	preds := pattern.To(patNode.ID())
+	for preds.Next() {
+		pred := preds.Node()
+		if hostPred, ok := mapping[pred.ID()]; ok {
+			if !host.HasEdgeFromTo(hostPred.ID(), candidate.ID()) {
+				return false
+			}
+		}
+	}
+	succs := pattern.From(patNode.ID())
+	for succs.Next() {
+		succ := succs.Node()
+		if hostSucc, ok := mapping[succ.ID()]; ok {
+			if !host.HasEdgeFromTo(candidate.ID(), hostSucc.ID()) {
+				return false
+			}
+		}
+	}
+	for patID, hostNode := range mapping {
+		if host.HasEdgeFromTo(hostNode.ID(), candidate.ID()) && !pattern.HasEdgeFromTo(patID, patNode.ID()) {
+			return false
+		}
+		if host.HasEdgeFromTo(candidate.ID(), hostNode.ID()) && !pattern.HasEdgeFromTo(patNode.ID(), patID) {
+			return false
+		}
+	}
+	return true
+}
+
+// label returns the DOT ID of n.
+func label(n graph.Node) string {
+	if n, ok := n.(cfa.Node); ok {
+		return n.DOTID()
+	}
+	return fmt.Sprint(n.ID())
+}
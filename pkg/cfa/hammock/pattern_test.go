@@ -0,0 +1,88 @@
+package hammock_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mewmew/lnp/pkg/cfa/hammock"
+	"github.com/mewmew/lnp/pkg/cfg"
+)
+
+// TestMatchPatternsSimplePattern verifies that a user-supplied pattern loaded
+// from a DOT file via LoadPatterns is actually found and merged by
+// MatchPatterns against a matching host graph.
+func TestMatchPatternsSimplePattern(t *testing.T) {
+	dir := t.TempDir()
+	const patternSrc = `digraph simple_seq {
+	entry [label="entry"]
+	exit [label="exit"]
+	entry -> exit
+}`
+	if err := os.WriteFile(filepath.Join(dir, "simple_seq.dot"), []byte(patternSrc), 0644); err != nil {
+		t.Fatalf("unable to write pattern fixture: %v", err)
+	}
+	if err := hammock.LoadPatterns(dir); err != nil {
+		t.Fatalf("LoadPatterns failed: %v", err)
+	}
+
+	const hostSrc = `digraph {
+	a [label="a"]
+	b [label="b"]
+	a -> b
+}`
+	host := cfg.NewGraph()
+	if err := cfg.ParseInto(strings.NewReader(hostSrc), host); err != nil {
+		t.Fatalf("unable to parse host CFG: %v", err)
+	}
+
+	prims := hammock.MatchPatterns(host, nil, nil)
+	if len(prims) != 1 {
+		t.Fatalf("got %d matched primitives, want 1: %v", len(prims), prims)
+	}
+	prim := prims[0]
+	if prim.Prim != "simple_seq" {
+		t.Errorf("got primitive %q, want %q", prim.Prim, "simple_seq")
+	}
+	if prim.Entry != "a" || prim.Exit != "b" {
+		t.Errorf("got entry/exit %q/%q, want %q/%q", prim.Entry, prim.Exit, "a", "b")
+	}
+}
+
+// TestMatchPatternsRejectsExtraEdge verifies that the matcher does not match
+// a host region that has an extra edge the pattern doesn't declare (e.g. a
+// fallthrough from entry straight to a third node), since that would discard
+// the extra edge and misclassify the real shape as the smaller pattern.
+func TestMatchPatternsRejectsExtraEdge(t *testing.T) {
+	dir := t.TempDir()
+	const patternSrc = `digraph simple_seq {
+	entry [label="entry"]
+	exit [label="exit"]
+	entry -> exit
+}`
+	if err := os.WriteFile(filepath.Join(dir, "simple_seq.dot"), []byte(patternSrc), 0644); err != nil {
+		t.Fatalf("unable to write pattern fixture: %v", err)
+	}
+	if err := hammock.LoadPatterns(dir); err != nil {
+		t.Fatalf("LoadPatterns failed: %v", err)
+	}
+
+	// a -> b, but also a -> c: an extra edge the two-node pattern above does
+	// not declare, so {a, b} must not match it.
+	const hostSrc = `digraph {
+	a [label="a"]
+	b [label="b"]
+	c [label="c"]
+	a -> b
+	a -> c
+}`
+	host := cfg.NewGraph()
+	if err := cfg.ParseInto(strings.NewReader(hostSrc), host); err != nil {
+		t.Fatalf("unable to parse host CFG: %v", err)
+	}
+
+	if prim, ok := hammock.TryPatterns(host); ok {
+		t.Fatalf("got unexpected match %v for a host node with an undeclared extra edge", prim)
+	}
+}
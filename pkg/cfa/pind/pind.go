@@ -0,0 +1,379 @@
+// Package pind implements a pattern-independent control flow recovery
+// method, based on structural analysis as described by Moretti et al.
+//
+// Unlike the hammock and interval methods, pind does not match the control
+// flow graph against a fixed library of primitive shapes (if, if_else,
+// pre_loop, ...). Instead, it classifies each interval of the derived
+// sequence of graphs by traversing its DFS tree and inspecting the shape of
+// its back-, forward- and cross-edges, producing generic primitives
+// (n_way_branch, proper_region, improper_region, loop) that describe the
+// region regardless of which concrete idiom produced it.
+package pind
+
+import (
+	"fmt"
+
+	"github.com/mewmew/lnp/pkg/cfa"
+	"github.com/mewmew/lnp/pkg/cfa/interval"
+	"github.com/mewmew/lnp/pkg/cfa/primitive"
+	"github.com/pkg/errors"
+	"gonum.org/v1/gonum/graph"
+)
+
+// Analyze attempts to recover the control flow primitives of g using
+// pattern-independent structural analysis.
+//
+// before and after, when non-nil, are invoked immediately before and after
+// each primitive is located and merged into the control flow graph, in the
+// same manner as hammock.Analyze and interval.Analyze.
+//
+// The returned error wraps cfa.ErrIncomplete if the control flow graph could
+// not be reduced to a single node.
+func Analyze(g *interval.Graph, before, after func(g cfa.Graph, prim *primitive.Primitive)) ([]*primitive.Primitive, error) {
+	var prims []*primitive.Primitive
+	Gs, _ := interval.DerivedSequence(g)
+	for _, level := range Gs {
+		for {
+			is := graph.NodesOf(level.Nodes())
+			if len(is) <= 1 {
+				break
+			}
+			prim, ok := locatePrimitive(level)
+			if !ok {
+				break
+			}
+			if before != nil {
+				before(level, prim)
+			}
+			merge(level, prim)
+			if after != nil {
+				after(level, prim)
+			}
+			prims = append(prims, prim)
+		}
+	}
+	if finalNodes := graph.NodesOf(Gs[len(Gs)-1].Nodes()); len(finalNodes) != 1 {
+		return prims, errors.WithStack(cfa.ErrIncomplete)
+	}
+	return prims, nil
+}
+
+// locatePrimitive locates the first structural primitive in g, classifying
+// the region rooted at each node candidate in turn until a match is found.
+func locatePrimitive(g *interval.Graph) (*primitive.Primitive, bool) {
+	nodes := graph.NodesOf(g.Nodes())
+	for _, entry := range nodes {
+		region, ok := classifyRegion(g, entry)
+		if !ok {
+			continue
+		}
+		return region, true
+	}
+	return nil, false
+}
+
+// classifyRegion classifies the shape of the region rooted at entry by
+// traversing its DFS tree and categorizing the non-tree edges reachable
+// before control leaves the region.
+func classifyRegion(g *interval.Graph, entry graph.Node) (*primitive.Primitive, bool) {
+	order, parent, tree, back, forward, cross := dfs(g, entry)
+	if len(order) < 2 {
+		return nil, false
+	}
+
+	// A back-edge targeting entry indicates a cyclic region rooted here;
+	// characterize it as a loop.
+	for _, e := range back {
+		if e.To().ID() == entry.ID() {
+			return classifyLoop(g, entry, order, back)
+		}
+	}
+	if len(back) > 0 {
+		// Every edge dfs classifies as "back" targets an ancestor of the
+		// current node on the DFS stack, so a back edge here that does not
+		// target entry targets some other ancestor within this region: an
+		// inner loop that has not yet been collapsed into a single node.
+		// Decline this candidate rather than running classifyAcyclic over a
+		// region that still contains an uncollapsed cycle; locatePrimitive
+		// will eventually try that inner header as its own entry candidate
+		// and resolve the loop first.
+		return nil, false
+	}
+
+	// No back-edge into the region: it is acyclic. Classify it by the shape
+	// of its forward/cross edges between entry, tail and any join nodes.
+	return classifyAcyclic(g, entry, order, parent, tree, forward, cross)
+}
+
+// classifyLoop characterizes the loop rooted at header by its latch (the
+// source of a back edge into header) and its set of exit nodes, producing a
+// single loop primitive tagged with the test position (pre-, post- or
+// mid-test) rather than a separate primitive per hard-coded shape.
+func classifyLoop(g *interval.Graph, header graph.Node, order []graph.Node, back []graph.Edge) (*primitive.Primitive, bool) {
+	// The loop holds header and every node of the region from which header
+	// is reachable again.
+	inLoop := map[int64]bool{header.ID(): true}
+	var body []graph.Node
+	for _, n := range order {
+		if inLoop[n.ID()] || reachableFrom(g, n)[header.ID()] {
+			inLoop[n.ID()] = true
+			body = append(body, n)
+		}
+	}
+	var latch graph.Node
+	for _, e := range back {
+		if e.To().ID() == header.ID() {
+			latch = e.From()
+		}
+	}
+	if latch == nil {
+		return nil, false
+	}
+
+	// Locate exit nodes: successors of loop nodes that lie outside the loop.
+	var exits []graph.Node
+	for _, n := range body {
+		to := g.From(n.ID())
+		for to.Next() {
+			succ := to.Node()
+			if !inLoop[succ.ID()] {
+				exits = append(exits, succ)
+			}
+		}
+	}
+	if len(exits) == 0 {
+		return nil, false
+	}
+
+	kind := loopKind(g, header, latch)
+	nodes := map[string]string{
+		"header": label(header),
+		"latch":  label(latch),
+		// kind records the test position of the loop (pre_test, post_test or
+		// mid_test); it is not itself a control flow graph node.
+		"kind": kind,
+	}
+	for i, exit := range exits {
+		nodes[fmt.Sprintf("exit%d", i)] = label(exit)
+	}
+	return &primitive.Primitive{
+		Prim:  "loop",
+		Nodes: nodes,
+		Entry: label(header),
+		Exit:  label(exits[0]),
+	}, true
+}
+
+// loopKind reports whether the loop with the given header and latch tests
+// its condition before the body (pre_test), after the body (post_test), or
+// from a node in the middle of the body (mid_test).
+func loopKind(g *interval.Graph, header, latch graph.Node) string {
+	headerSuccs := g.From(header.ID())
+	latchSuccs := g.From(latch.ID())
+	switch {
+	case headerSuccs.Len() == 2 && header.ID() != latch.ID():
+		return "pre_test"
+	case latchSuccs.Len() == 2:
+		return "post_test"
+	default:
+		return "mid_test"
+	}
+}
+
+// classifyAcyclic classifies an acyclic region rooted at entry, producing an
+// n-way branch primitive when entry has more than two successors that all
+// rejoin at a common tail, a proper region when the join structure is
+// reducible, or an improper region otherwise.
+func classifyAcyclic(g *interval.Graph, entry graph.Node, order []graph.Node, parent map[int64]graph.Node, tree, forward, cross []graph.Edge) (*primitive.Primitive, bool) {
+	succs := graph.NodesOf(g.From(entry.ID()))
+	if len(succs) < 2 {
+		return nil, false
+	}
+
+	tail, ok := commonTail(g, entry, succs, order)
+	if !ok {
+		// No node is reachable from every branch of entry; the region is
+		// irreducible with respect to this entry node. Bound the primitive to
+		// the nodes actually reachable from more than one branch (the nodes in
+		// conflict), rather than every node reachable from entry.
+		return improperRegion(g, entry, succs, order), true
+	}
+
+	nodes := map[string]string{
+		"entry": label(entry),
+		"exit":  label(tail),
+	}
+	// afterTail holds tail and everything reachable from it, so the interior
+	// nodes of each branch (everything strictly between a successor and
+	// tail) can be told apart from nodes past the primitive's boundary. Every
+	// node merge() is expected to collapse must be named in Nodes, or it is
+	// left behind in g with edges dangling into the newly-merged node.
+	afterTail := reachableFrom(g, tail)
+	for i, succ := range succs {
+		nodes[fmt.Sprintf("case%d", i)] = label(succ)
+		branch := reachableFrom(g, succ)
+		k := 0
+		for _, n := range order {
+			if n.ID() == entry.ID() || n.ID() == succ.ID() || !branch[n.ID()] || afterTail[n.ID()] {
+				continue
+			}
+			nodes[fmt.Sprintf("case%d_n%d", i, k)] = label(n)
+			k++
+		}
+	}
+	prim := "n_way_branch"
+	if len(succs) == 2 {
+		prim = "proper_region"
+	}
+	return &primitive.Primitive{
+		Prim:  prim,
+		Nodes: nodes,
+		Entry: label(entry),
+		Exit:  label(tail),
+	}, true
+}
+
+// improperRegion produces a catch-all primitive for an acyclic region whose
+// join structure could not be reduced to a single tail node. The primitive is
+// bounded to entry and the nodes reachable from more than one of entry's
+// branches (the nodes actually in conflict), rather than every node reachable
+// from entry.
+func improperRegion(g *interval.Graph, entry graph.Node, succs, order []graph.Node) *primitive.Primitive {
+	reach := make([]map[int64]bool, len(succs))
+	for i, succ := range succs {
+		reach[i] = reachableFrom(g, succ)
+	}
+	nreach := make(map[int64]int, len(order))
+	for _, r := range reach {
+		for id := range r {
+			nreach[id]++
+		}
+	}
+	var region []graph.Node
+	for _, n := range order {
+		if n.ID() == entry.ID() || nreach[n.ID()] >= 2 {
+			region = append(region, n)
+		}
+	}
+	if len(region) == 0 {
+		region = []graph.Node{entry}
+	}
+	nodes := make(map[string]string, len(region))
+	for i, n := range region {
+		nodes[fmt.Sprintf("n%d", i)] = label(n)
+	}
+	return &primitive.Primitive{
+		Prim:  "improper_region",
+		Nodes: nodes,
+		Entry: label(entry),
+		Exit:  label(region[len(region)-1]),
+	}
+}
+
+// commonTail reports a node at which every branch in succs rejoins -- the
+// first node reachable from all of succs -- and a boolean indicating whether
+// such a node was found. Being reachable from every branch does not imply
+// that the node post-dominates them. Candidates are
+// considered in the DFS preorder from entry (order) so that the nearest join
+// point is returned rather than some arbitrary node further downstream.
+func commonTail(g *interval.Graph, entry graph.Node, succs, order []graph.Node) (graph.Node, bool) {
+	reach := make([]map[int64]bool, len(succs))
+	for i, succ := range succs {
+		reach[i] = reachableFrom(g, succ)
+	}
+	for _, n := range order {
+		if n.ID() == entry.ID() {
+			continue
+		}
+		joined := true
+		for _, r := range reach {
+			if !r[n.ID()] {
+				joined = false
+				break
+			}
+		}
+		if joined {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// reachableFrom returns the set of node IDs reachable from n in g, including
+// n itself.
+func reachableFrom(g *interval.Graph, n graph.Node) map[int64]bool {
+	visited := map[int64]bool{n.ID(): true}
+	queue := []graph.Node{n}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		to := g.From(cur.ID())
+		for to.Next() {
+			succ := to.Node()
+			if !visited[succ.ID()] {
+				visited[succ.ID()] = true
+				queue = append(queue, succ)
+			}
+		}
+	}
+	return visited
+}
+
+// merge replaces the nodes of prim in g with a single node representing the
+// recovered primitive, in the same manner as the hammock and interval
+// methods.
+func merge(g *interval.Graph, prim *primitive.Primitive) {
+	g.Merge(prim)
+}
+
+// dfs performs a depth-first traversal of g rooted at entry, classifying
+// every edge as a tree, back, forward or cross edge.
+func dfs(g *interval.Graph, entry graph.Node) (order []graph.Node, parent map[int64]graph.Node, tree, back, forward, cross []graph.Edge) {
+	parent = make(map[int64]graph.Node)
+	discovered := make(map[int64]bool)
+	finished := make(map[int64]bool)
+	var visit func(n graph.Node)
+	visit = func(n graph.Node) {
+		discovered[n.ID()] = true
+		order = append(order, n)
+		to := g.From(n.ID())
+		for to.Next() {
+			succ := to.Node()
+			e := g.Edge(n.ID(), succ.ID())
+			switch {
+			case !discovered[succ.ID()]:
+				parent[succ.ID()] = n
+				tree = append(tree, e)
+				visit(succ)
+			case discovered[succ.ID()] && !finished[succ.ID()]:
+				back = append(back, e)
+			case isAncestor(parent, n, succ):
+				forward = append(forward, e)
+			default:
+				cross = append(cross, e)
+			}
+		}
+		finished[n.ID()] = true
+	}
+	visit(entry)
+	return order, parent, tree, back, forward, cross
+}
+
+// isAncestor reports whether anc is an ancestor of n in the DFS tree encoded
+// by parent.
+func isAncestor(parent map[int64]graph.Node, anc, n graph.Node) bool {
+	for cur := parent[n.ID()]; cur != nil; cur = parent[cur.ID()] {
+		if cur.ID() == anc.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns the DOT ID of n.
+func label(n graph.Node) string {
+	if n, ok := n.(cfa.Node); ok {
+		return n.DOTID()
+	}
+	return fmt.Sprint(n.ID())
+}
@@ -0,0 +1,91 @@
+package pind_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mewmew/lnp/pkg/cfa/interval"
+	"github.com/mewmew/lnp/pkg/cfa/pind"
+	"github.com/mewmew/lnp/pkg/cfg"
+)
+
+// TestAnalyzeIfElseMultiNodeBranch verifies that a plain if/else whose "then"
+// branch is more than one node long (entry -> then -> mid -> join, entry ->
+// els -> join) is reduced to a single proper_region primitive naming every
+// interior branch node, rather than leaving "mid" behind in the graph with
+// edges dangling into the merged node.
+func TestAnalyzeIfElseMultiNodeBranch(t *testing.T) {
+	const src = `digraph {
+	entry [label="entry"]
+	then [label="then"]
+	mid [label="mid"]
+	els [label="els"]
+	join [label="join"]
+	entry -> then
+	entry -> els
+	then -> mid
+	mid -> join
+	els -> join
+}`
+	g := interval.NewGraph()
+	if err := cfg.ParseInto(strings.NewReader(src), g); err != nil {
+		t.Fatalf("unable to parse test CFG: %v", err)
+	}
+	prims, err := pind.Analyze(g, nil, nil)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if len(prims) != 1 {
+		t.Fatalf("got %d primitives, want 1: %v", len(prims), prims)
+	}
+	prim := prims[0]
+	if prim.Prim != "proper_region" {
+		t.Errorf("got primitive %q, want %q", prim.Prim, "proper_region")
+	}
+	for _, want := range []string{"entry", "then", "mid", "els", "join"} {
+		found := false
+		for _, label := range prim.Nodes {
+			if label == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("primitive %v missing node %q", prim.Nodes, want)
+		}
+	}
+}
+
+// TestAnalyzeLoopWithNestedHeader verifies that an outer region containing an
+// inner loop (outer -> header -> body -> header (back edge), header -> exit)
+// resolves the inner loop into a single "loop" primitive before the outer
+// region is ever classified as acyclic.
+func TestAnalyzeLoopWithNestedHeader(t *testing.T) {
+	const src = `digraph {
+	outer [label="outer"]
+	header [label="header"]
+	body [label="body"]
+	exit [label="exit"]
+	outer -> header
+	header -> body
+	header -> exit
+	body -> header
+}`
+	g := interval.NewGraph()
+	if err := cfg.ParseInto(strings.NewReader(src), g); err != nil {
+		t.Fatalf("unable to parse test CFG: %v", err)
+	}
+	prims, err := pind.Analyze(g, nil, nil)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var sawLoop bool
+	for _, prim := range prims {
+		if prim.Prim == "loop" {
+			sawLoop = true
+		}
+	}
+	if !sawLoop {
+		t.Errorf("got primitives %v, want a loop primitive among them", prims)
+	}
+}